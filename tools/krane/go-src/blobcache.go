@@ -0,0 +1,301 @@
+package main
+
+// This file adds an on-disk, content-addressable cache for blob responses, in the spirit of the
+// blob cache used by buildah/containers-image. Twoliter re-resolves the same handful of base
+// images across many builds, and caching blobs (never manifests or tags, which can legitimately
+// change under a ref) turns repeated pulls of those layers into a local copy after the first.
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	// #include <stdint.h>
+	"C"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+)
+
+// blobCacheEnvVar lets hosts point at a cache directory without going through the FFI surface.
+const blobCacheEnvVar = "KRANE_BLOB_CACHE_DIR"
+
+var blobURLPattern = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[0-9a-f]{64})$`)
+
+var (
+	blobCacheMu  sync.RWMutex
+	blobCacheDir string
+	blobCacheMax int64
+)
+
+//export krane_set_blob_cache
+func krane_set_blob_cache(path *C.char, maxBytes C.int64_t) C.int {
+	blobCacheMu.Lock()
+	defer blobCacheMu.Unlock()
+
+	blobCacheDir = C.GoString(path)
+	blobCacheMax = int64(maxBytes)
+
+	return 0
+}
+
+//export krane_prune_blob_cache
+func krane_prune_blob_cache() C.int {
+	dir, maxBytes := currentBlobCacheConfig()
+	if dir == "" {
+		return 0
+	}
+	if err := pruneBlobCache(dir, maxBytes); err != nil {
+		logs.Warn.Printf("krane: failed to prune blob cache at %q: %v", dir, err)
+		return 1
+	}
+	return 0
+}
+
+func currentBlobCacheConfig() (dir string, maxBytes int64) {
+	blobCacheMu.RLock()
+	dir, maxBytes = blobCacheDir, blobCacheMax
+	blobCacheMu.RUnlock()
+
+	if dir != "" {
+		return dir, maxBytes
+	}
+
+	dir = os.Getenv(blobCacheEnvVar)
+	if dir == "" {
+		return "", 0
+	}
+	if raw := os.Getenv(blobCacheEnvVar + "_MAX_BYTES"); raw != "" {
+		if parsed, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			maxBytes = parsed
+		}
+	}
+	return dir, maxBytes
+}
+
+// wrapBlobCacheTransport wraps base with the currently configured blob cache, or returns base
+// unchanged if no cache directory is set.
+func wrapBlobCacheTransport(base http.RoundTripper) http.RoundTripper {
+	dir, maxBytes := currentBlobCacheConfig()
+	if dir == "" {
+		return base
+	}
+	return &blobCacheTransport{dir: dir, maxSize: maxBytes, base: base}
+}
+
+// blobCacheTransport is an http.RoundTripper that serves blob GET/HEAD requests out of a local
+// content-addressable cache, keyed by the blob's digest (never manifests or tags, since those
+// can change meaning under the same URL).
+type blobCacheTransport struct {
+	dir     string
+	maxSize int64
+	base    http.RoundTripper
+}
+
+func (t *blobCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	digest, ok := blobDigestFromPath(req.URL.Path)
+	if !ok || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return t.base.RoundTrip(req)
+	}
+
+	cachePath := t.pathFor(digest)
+
+	if info, err := os.Stat(cachePath); err == nil {
+		return t.servedFromCache(req, cachePath, digest, info.Size())
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.Method != http.MethodGet {
+		return resp, err
+	}
+
+	return t.teeIntoCache(resp, cachePath, digest)
+}
+
+func (t *blobCacheTransport) pathFor(digest string) string {
+	algo, digestHex, _ := splitDigest(digest)
+	return filepath.Join(t.dir, algo, digestHex)
+}
+
+func (t *blobCacheTransport) servedFromCache(req *http.Request, cachePath, digest string, size int64) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	header.Set("Docker-Content-Digest", digest)
+
+	// Touch the file's mtime on every hit so pruneBlobCache evicts by last access, not just by
+	// when the blob was written, and a frequently-read blob doesn't look as cold as a stale one.
+	touchBlobCacheFile(cachePath)
+
+	if req.Method == http.MethodHead {
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody, Request: req}, nil
+	}
+
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{StatusCode: http.StatusOK, Header: header, Body: f, Request: req}, nil
+}
+
+// touchBlobCacheFile updates cachePath's mtime to now. Caching is best-effort, so a failure here
+// (e.g. a read-only filesystem) just means that entry reverts to write-time eviction ordering.
+func touchBlobCacheFile(cachePath string) {
+	now := time.Now()
+	_ = os.Chtimes(cachePath, now, now)
+}
+
+// teeIntoCache tees the upstream response body into a temp file while it's being read by the
+// caller, then atomically renames it into place, but only if the bytes we wrote actually hash to
+// the digest the caller asked for; otherwise we drop the temp file rather than poison the cache.
+func (t *blobCacheTransport) teeIntoCache(resp *http.Response, cachePath, digest string) (*http.Response, error) {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		// Caching is best-effort: fall back to serving the upstream response uncached.
+		return resp, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".tmp-*")
+	if err != nil {
+		return resp, nil
+	}
+
+	hasher := sha256.New()
+	resp.Body = &teeReadCloser{
+		rc: resp.Body,
+		w:  io.MultiWriter(tmp, hasher),
+		onEOF: func() {
+			finalizeBlobCacheFile(tmp, cachePath, digest, hasher, t.dir, t.maxSize)
+		},
+	}
+
+	return resp, nil
+}
+
+func finalizeBlobCacheFile(tmp *os.File, cachePath, digest string, hasher interface{ Sum([]byte) []byte }, dir string, maxBytes int64) {
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	algo, digestHex, ok := splitDigest(digest)
+	if !ok || algo != "sha256" {
+		return
+	}
+
+	sum := hasher.Sum(nil)
+	if digestHex != fmt.Sprintf("%x", sum) {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return
+	}
+
+	// A write is the only thing that grows the cache, so this is the natural place to enforce
+	// max_bytes -- otherwise a host that never calls krane_prune_blob_cache itself would have an
+	// unbounded cache despite setting one.
+	if err := pruneBlobCache(dir, maxBytes); err != nil {
+		logs.Warn.Printf("krane: failed to prune blob cache at %q: %v", dir, err)
+	}
+}
+
+// teeReadCloser tees everything read through it into w, calling onEOF exactly once (on the
+// first error from a Read, or on Close if the body is never fully read) so the cache write
+// always gets finalized.
+type teeReadCloser struct {
+	rc    io.ReadCloser
+	w     io.Writer
+	onEOF func()
+	done  bool
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		_, _ = t.w.Write(p[:n])
+	}
+	if err != nil && !t.done {
+		t.done = true
+		t.onEOF()
+	}
+	return n, err
+}
+
+func (t *teeReadCloser) Close() error {
+	if !t.done {
+		t.done = true
+		t.onEOF()
+	}
+	return t.rc.Close()
+}
+
+func blobDigestFromPath(path string) (string, bool) {
+	m := blobURLPattern.FindStringSubmatch(path)
+	if m == nil {
+		return "", false
+	}
+	return m[2], true
+}
+
+func splitDigest(digest string) (algo, digestHex string, ok bool) {
+	for i := 0; i < len(digest); i++ {
+		if digest[i] == ':' {
+			return digest[:i], digest[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// pruneBlobCache walks the cache directory and removes the least-recently-accessed files until
+// the total size is back under maxBytes.
+func pruneBlobCache(dir string, maxBytes int64) error {
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime int64
+	}
+	var entries []entry
+	var total int64
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime().Unix()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if total <= maxBytes {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime < entries[j].modTime })
+
+	for _, e := range entries {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			continue
+		}
+		total -= e.size
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func blobRequest(t *testing.T, digest string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/repo/blobs/"+digest, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	return req
+}
+
+func TestBlobCacheTransportCachesOnDigestMatch(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte("hello world")
+	sum := sha256.Sum256(body)
+	digest := fmt.Sprintf("sha256:%x", sum)
+
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body)), Request: req}, nil
+	})
+	transport := &blobCacheTransport{dir: dir, base: base}
+
+	resp, err := transport.RoundTrip(blobRequest(t, digest))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := os.Stat(transport.pathFor(digest)); err != nil {
+		t.Fatalf("expected blob to be cached at %s, got: %v", transport.pathFor(digest), err)
+	}
+}
+
+func TestBlobCacheTransportSkipsCacheOnDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte("hello world")
+	wrongDigest := "sha256:" + fmt.Sprintf("%064x", 0)
+
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body)), Request: req}, nil
+	})
+	transport := &blobCacheTransport{dir: dir, base: base}
+
+	resp, err := transport.RoundTrip(blobRequest(t, wrongDigest))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	resp.Body.Close()
+
+	cachePath := transport.pathFor(wrongDigest)
+	if _, err := os.Stat(cachePath); err == nil {
+		t.Fatalf("blob whose bytes don't hash to the requested digest should not be cached, found %s", cachePath)
+	} else if !os.IsNotExist(err) {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(cachePath))
+	if err == nil {
+		for _, e := range entries {
+			if e.Name()[0] == '.' {
+				t.Errorf("leftover temp file %s after digest mismatch", e.Name())
+			}
+		}
+	}
+}
+
+func TestServedFromCacheTouchesMtime(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte("hello world")
+	sum := sha256.Sum256(body)
+	digest := fmt.Sprintf("sha256:%x", sum)
+
+	transport := &blobCacheTransport{dir: dir}
+	cachePath := transport.pathFor(digest)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(cachePath, body, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(cachePath, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	resp, err := transport.servedFromCache(blobRequest(t, digest), cachePath, digest, int64(len(body)))
+	if err != nil {
+		t.Fatalf("servedFromCache: %v", err)
+	}
+	resp.Body.Close()
+
+	info, err := os.Stat(cachePath)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !info.ModTime().After(old) {
+		t.Errorf("expected a cache hit to bump mtime past %v, got %v", old, info.ModTime())
+	}
+}
+
+func TestBlobCacheTransportEvictsOnWriteWhenOverMaxSize(t *testing.T) {
+	dir := t.TempDir()
+
+	oldBody := []byte("a stale blob that should get evicted")
+	oldSum := sha256.Sum256(oldBody)
+	oldDigest := fmt.Sprintf("sha256:%x", oldSum)
+
+	transport := &blobCacheTransport{dir: dir, maxSize: int64(len(oldBody))}
+	oldPath := transport.pathFor(oldDigest)
+	if err := os.MkdirAll(filepath.Dir(oldPath), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(oldPath, oldBody, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(oldPath, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	newBody := []byte("a fresh blob that just got pulled")
+	newSum := sha256.Sum256(newBody)
+	newDigest := fmt.Sprintf("sha256:%x", newSum)
+
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(newBody)), Request: req}, nil
+	})
+	transport.base = base
+
+	resp, err := transport.RoundTrip(blobRequest(t, newDigest))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("expected the older blob to be evicted once the new write pushed the cache over max_bytes, stat err: %v", err)
+	}
+	if _, err := os.Stat(transport.pathFor(newDigest)); err != nil {
+		t.Errorf("expected the newly written blob to remain cached, got: %v", err)
+	}
+}
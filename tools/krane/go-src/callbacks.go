@@ -0,0 +1,208 @@
+package main
+
+// This file implements krane_with_callbacks, which streams log lines and progress updates to
+// C callbacks as they happen instead of buffering everything until the command completes. This
+// matters for large image copies, where buffering stdout/stderr (as `krane` does) means the
+// caller sees nothing until the whole transfer is done.
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef void (*krane_log_cb)(int level, const char *msg, void *user);
+typedef void (*krane_progress_cb)(int64_t complete, int64_t total, void *user);
+
+static inline void krane_call_log_cb(krane_log_cb cb, int level, const char *msg, void *user) {
+	cb(level, msg, user);
+}
+
+static inline void krane_call_progress_cb(krane_progress_cb cb, int64_t complete, int64_t total, void *user) {
+	cb(complete, total, user);
+}
+*/
+import "C"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/google/go-containerregistry/cmd/crane/cmd"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/logs"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+)
+
+// Log levels passed to log_cb, matching the three logs.* writers krane already configures.
+const (
+	logLevelDebug    C.int = 0
+	logLevelProgress C.int = 1
+	logLevelWarn     C.int = 2
+)
+
+// logRedirectMu serializes the redirect/run/restore sequence below, since logs.Debug/Progress/Warn
+// are package-level *log.Logger vars in go-containerregistry shared by every invocation in this
+// process: two overlapping krane_with_callbacks calls would otherwise race on SetOutput, each
+// misrouting the other's log lines and potentially restoring defaults out from under a call
+// that's still running. This only protects krane_with_callbacks against itself; a plain krane()/
+// krane_inherited_io()/krane_with_context() call running concurrently still writes through
+// whatever a callback call has installed, since go-containerregistry doesn't offer per-call
+// writers -- fixing that fully would need an upstream change.
+var logRedirectMu sync.Mutex
+
+// callbackDispatcher serializes calls into C callbacks onto a single goroutine so the C side
+// only ever needs to be reentrancy-safe with respect to itself, not with respect to however many
+// goroutines krane happens to be running internally.
+type callbackDispatcher struct {
+	queue chan func()
+	done  chan struct{}
+}
+
+func newCallbackDispatcher() *callbackDispatcher {
+	d := &callbackDispatcher{
+		queue: make(chan func(), 64),
+		done:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *callbackDispatcher) run() {
+	defer close(d.done)
+	for fn := range d.queue {
+		fn()
+	}
+}
+
+func (d *callbackDispatcher) dispatch(fn func()) {
+	d.queue <- fn
+}
+
+func (d *callbackDispatcher) close() {
+	close(d.queue)
+	<-d.done
+}
+
+// callbackLogWriter is an io.Writer that parses each written chunk into lines and forwards each
+// one to log_cb at a fixed level, via the dispatcher so calls from multiple writers never
+// interleave on the C side.
+type callbackLogWriter struct {
+	level      C.int
+	logCb      C.krane_log_cb
+	userData   unsafe.Pointer
+	dispatcher *callbackDispatcher
+}
+
+func (w callbackLogWriter) Write(p []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(p))
+	for scanner.Scan() {
+		line := C.CString(scanner.Text())
+		level := w.level
+		logCb := w.logCb
+		userData := w.userData
+		w.dispatcher.dispatch(func() {
+			C.krane_call_log_cb(logCb, level, line, userData)
+			C.free(unsafe.Pointer(line))
+		})
+	}
+	return len(p), nil
+}
+
+//export krane_with_callbacks
+func krane_with_callbacks(argc C.int, argv **C.char, logCb C.krane_log_cb, progressCb C.krane_progress_cb, userData unsafe.Pointer) C.int {
+	args := parseCArgs(argc, argv)
+
+	dispatcher := newCallbackDispatcher()
+	defer dispatcher.close()
+
+	if logCb != nil {
+		// Held for the whole redirect-run-restore sequence below (not just the SetOutput calls)
+		// so a second krane_with_callbacks running concurrently can't install its own writers,
+		// or restore defaults, while this call is still in flight.
+		logRedirectMu.Lock()
+		defer logRedirectMu.Unlock()
+
+		logs.Debug.SetOutput(callbackLogWriter{level: logLevelDebug, logCb: logCb, userData: userData, dispatcher: dispatcher})
+		logs.Progress.SetOutput(callbackLogWriter{level: logLevelProgress, logCb: logCb, userData: userData, dispatcher: dispatcher})
+		logs.Warn.SetOutput(callbackLogWriter{level: logLevelWarn, logCb: logCb, userData: userData, dispatcher: dispatcher})
+		defer func() {
+			logs.Debug.SetOutput(io.Discard)
+			logs.Progress.SetOutput(os.Stderr)
+			logs.Warn.SetOutput(os.Stderr)
+		}()
+	}
+
+	var opts []crane.Option
+	var updates chan v1.Update
+	var progressDone chan struct{}
+
+	if progressCb != nil {
+		updates = make(chan v1.Update, 64)
+		// crane has no top-level WithProgress; it's a remote.Option, so we fold it into a
+		// crane.Option the same way crane.WithTransport folds in remote.WithTransport.
+		opts = append(opts, func(o *crane.Options) {
+			o.Remote = append(o.Remote, remote.WithProgress(updates))
+		})
+
+		progressDone = make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			for update := range updates {
+				complete, total := C.int64_t(update.Complete), C.int64_t(update.Total)
+				dispatcher.dispatch(func() {
+					C.krane_call_progress_cb(progressCb, complete, total, userData)
+				})
+			}
+		}()
+	}
+
+	opts = append([]crane.Option{
+		crane.WithAuthFromKeychain(defaultKeychain()),
+		crane.WithTransport(composedTransport()),
+	}, opts...)
+
+	root := cmd.New(use, short, opts)
+	root.AddCommand(newSignCmd(), newVerifyCmd())
+	root.SetArgs(args)
+
+	err := runCraneCommand(context.Background(), root, updates)
+
+	if progressCb != nil {
+		<-progressDone
+	}
+
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// runCraneCommand runs root to completion and, if progressUpdates is non-nil, closes it
+// afterward -- remote.WithProgress's channel is only closed by subcommands that actually push
+// (remote.Write/WriteIndex/MultiWrite); pull, digest, manifest, etc. never touch it, so without
+// this a caller that wired a progress callback into a non-pushing subcommand would have its
+// drain goroutine (and therefore the whole call) block forever. Pulled out of
+// krane_with_callbacks so it can be exercised by a test without going through cgo.
+func runCraneCommand(ctx context.Context, root *cobra.Command, progressUpdates chan v1.Update) error {
+	err := root.ExecuteContext(ctx)
+
+	if progressUpdates != nil {
+		closeUpdates(progressUpdates)
+	}
+
+	return err
+}
+
+// closeUpdates closes a remote.WithProgress channel, tolerating one already closed by crane's
+// own remote.Write/WriteIndex/MultiWrite path.
+func closeUpdates(updates chan v1.Update) {
+	defer func() { _ = recover() }()
+	close(updates)
+}
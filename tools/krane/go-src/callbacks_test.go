@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/cmd/crane/cmd"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// TestRunCraneCommandPullDoesNotDeadlock exercises the exact scenario this request exists for: a
+// progress channel wired into a subcommand (pull) that never calls remote.Write and so never
+// closes it itself. runCraneCommand must still return, and the channel must actually get closed
+// so a caller draining it isn't left blocked on a `for range`.
+func TestRunCraneCommandPullDoesNotDeadlock(t *testing.T) {
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+	registryHost := strings.TrimPrefix(srv.URL, "http://")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image: %v", err)
+	}
+	refStr := registryHost + "/repo:latest"
+	ref, err := name.ParseReference(refStr)
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("pushing test image: %v", err)
+	}
+
+	tarball := filepath.Join(t.TempDir(), "image.tar")
+
+	updates := make(chan v1.Update, 64)
+	opts := []crane.Option{func(o *crane.Options) {
+		o.Remote = append(o.Remote, remote.WithProgress(updates))
+	}}
+
+	root := cmd.New(use, short, opts)
+	root.SetArgs([]string{"pull", refStr, tarball})
+
+	drainDone := make(chan struct{})
+	go func() {
+		defer close(drainDone)
+		for range updates {
+		}
+	}()
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- runCraneCommand(context.Background(), root, updates)
+	}()
+
+	select {
+	case err := <-runDone:
+		if err != nil {
+			t.Errorf("runCraneCommand(pull) returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runCraneCommand(pull) with a progress channel deadlocked")
+	}
+
+	select {
+	case <-drainDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("progress channel was never closed, drain goroutine is stuck")
+	}
+}
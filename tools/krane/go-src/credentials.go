@@ -0,0 +1,112 @@
+package main
+
+// This file lets embedders register additional credentials at runtime instead of only relying
+// on the hardcoded ECR/ACR/GCP/GitHub/docker-config keychains in defaultKeychain. This is how
+// twoliter's Rust side injects site-specific auth (an internal Artifactory, an ephemeral CI
+// token) into this cgo module without a recompile.
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+var (
+	credentialHelperMu sync.Mutex
+	credentialHelpers  []authn.Keychain
+
+	staticCredentialMu sync.RWMutex
+	staticCredentials  = map[string]authn.AuthConfig{}
+)
+
+//export krane_register_credential_helper
+func krane_register_credential_helper(name *C.char, binaryPath *C.char) C.int {
+	helper := newHelperKeychain(C.GoString(name), C.GoString(binaryPath))
+
+	credentialHelperMu.Lock()
+	credentialHelpers = append(credentialHelpers, helper)
+	credentialHelperMu.Unlock()
+
+	return 0
+}
+
+//export krane_register_static_credential
+func krane_register_static_credential(registry *C.char, username *C.char, password *C.char) C.int {
+	staticCredentialMu.Lock()
+	staticCredentials[C.GoString(registry)] = authn.AuthConfig{
+		Username: C.GoString(username),
+		Password: C.GoString(password),
+	}
+	staticCredentialMu.Unlock()
+
+	return 0
+}
+
+// registeredCredentialHelpers returns the credential-helper keychains registered so far via
+// krane_register_credential_helper, in registration order.
+func registeredCredentialHelpers() []authn.Keychain {
+	credentialHelperMu.Lock()
+	defer credentialHelperMu.Unlock()
+
+	helpers := make([]authn.Keychain, len(credentialHelpers))
+	copy(helpers, credentialHelpers)
+	return helpers
+}
+
+// helperGetResponse is the JSON shape a docker-credential-helpers-style binary writes to stdout
+// in response to a "get" request.
+type helperGetResponse struct {
+	Username string `json:"Username"`
+	Secret   string `json:"Secret"`
+}
+
+// execHelper shells out to an external docker-credential-helpers-compatible binary to resolve
+// credentials for a server URL, implementing the authn.Helper interface that
+// authn.NewKeychainFromHelper expects.
+type execHelper struct {
+	name       string
+	binaryPath string
+}
+
+func (h execHelper) Get(serverURL string) (string, string, error) {
+	cmd := exec.Command(h.binaryPath, "get")
+	cmd.Stdin = bytes.NewBufferString(serverURL)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("credential helper %q: %w", h.name, err)
+	}
+
+	var resp helperGetResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return "", "", fmt.Errorf("credential helper %q: parsing response: %w", h.name, err)
+	}
+
+	return resp.Username, resp.Secret, nil
+}
+
+func newHelperKeychain(name, binaryPath string) authn.Keychain {
+	return authn.NewKeychainFromHelper(execHelper{name: name, binaryPath: binaryPath})
+}
+
+// staticCredentialKeychain is an authn.Keychain backed by the per-host credentials registered
+// via krane_register_static_credential.
+type staticCredentialKeychain struct{}
+
+func (staticCredentialKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	staticCredentialMu.RLock()
+	cfg, ok := staticCredentials[target.RegistryStr()]
+	staticCredentialMu.RUnlock()
+
+	if !ok {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(cfg), nil
+}
@@ -11,12 +11,26 @@ package main
 //
 // Note: These strings must be freed by the caller.
 //
+// Long-running invocations can be made cancelable: call krane_new_context to mint an opaque
+// handle, pass it to krane_with_context (or krane_inherited_io_with_context, for the
+// inherited-stdio variant) alongside the usual args, and call krane_cancel from another thread
+// to abort the in-flight operation. This exists because hosts loading this library via cgo
+// (twoliter's Rust side, for example) can't safely install their own signal handler the way
+// `cmd/krane`'s standalone binary does with os/signal.Notify.
+//
+// Embedders can also register additional credentials at runtime, without a recompile, via
+// krane_register_credential_helper and krane_register_static_credential.
+//
 // [google/go-containerregistry]: https://github.com/google/go-containerregistry
 
 import (
+	"context"
 	"io"
+	"net/http"
 	"os"
+	"sync"
 
+	// #include <stdint.h>
 	"C"
 	"unsafe"
 
@@ -44,7 +58,7 @@ func krane(argc C.int, argv **C.char, stdout **C.char, stderr **C.char) C.int {
 
 	var outBuffer, errBuffer bytes.Buffer
 
-	statusCode := kraneMain(args, false, &outBuffer, &errBuffer)
+	statusCode := kraneMain(context.Background(), args, false, &outBuffer, &errBuffer)
 
 	*stdout = C.CString(outBuffer.String())
 	*stderr = C.CString(errBuffer.String())
@@ -56,11 +70,102 @@ func krane(argc C.int, argv **C.char, stdout **C.char, stderr **C.char) C.int {
 func krane_inherited_io(argc C.int, argv **C.char) C.int {
 	args := parseCArgs(argc, argv)
 
-	statusCode := kraneMain(args, true, nil, nil)
+	statusCode := kraneMain(context.Background(), args, true, nil, nil)
+
+	return C.int(statusCode)
+}
+
+//export krane_inherited_io_with_context
+func krane_inherited_io_with_context(handle C.uintptr_t, argc C.int, argv **C.char) C.int {
+	args := parseCArgs(argc, argv)
+	ctx := contextForHandle(handle)
+	defer releaseContextHandle(handle)
+
+	statusCode := kraneMain(ctx, args, true, nil, nil)
 
 	return C.int(statusCode)
 }
 
+// contextHandles tracks cancelable contexts handed out to C callers, keyed by an
+// opaque handle minted by krane_new_context.
+var (
+	contextHandles    sync.Map // uintptr -> context.CancelFunc
+	contextHandlesCtx sync.Map // uintptr -> context.Context
+	nextContextHandle uintptr
+	contextHandleMu   sync.Mutex
+)
+
+//export krane_new_context
+func krane_new_context() C.uintptr_t {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	contextHandleMu.Lock()
+	nextContextHandle++
+	handle := nextContextHandle
+	contextHandleMu.Unlock()
+
+	contextHandlesCtx.Store(handle, ctx)
+	contextHandles.Store(handle, cancel)
+
+	return C.uintptr_t(handle)
+}
+
+//export krane_cancel
+func krane_cancel(handle C.uintptr_t) C.int {
+	if !releaseContextHandle(handle) {
+		// Already canceled and freed, or never allocated: guard against double-free.
+		return 1
+	}
+	return 0
+}
+
+// contextForHandle resolves a handle minted by krane_new_context back to its
+// context.Context, falling back to a background context if the handle is unknown
+// (e.g. already canceled) so callers never block forever on a bad handle.
+func contextForHandle(handle C.uintptr_t) context.Context {
+	if ctx, ok := contextHandlesCtx.Load(uintptr(handle)); ok {
+		return ctx.(context.Context)
+	}
+	return context.Background()
+}
+
+//export krane_with_context
+func krane_with_context(handle C.uintptr_t, argc C.int, argv **C.char, stdout **C.char, stderr **C.char) C.int {
+	args := parseCArgs(argc, argv)
+	ctx := contextForHandle(handle)
+	// A run that finishes on its own is done with this handle just as much as one canceled
+	// via krane_cancel; release it here too so a caller who never calls krane_cancel on the
+	// success path doesn't leak the context and its cancel func forever.
+	defer releaseContextHandle(handle)
+
+	var outBuffer, errBuffer bytes.Buffer
+
+	statusCode := kraneMain(ctx, args, false, &outBuffer, &errBuffer)
+
+	*stdout = C.CString(outBuffer.String())
+	*stderr = C.CString(errBuffer.String())
+
+	return C.int(statusCode)
+}
+
+// releaseContextHandle removes handle from both maps and invokes its cancel func, if it's still
+// present, reporting whether it actually did so. It's safe to call more than once for the same
+// handle (e.g. once from krane_with_context on return and once from a racing krane_cancel) --
+// only the call that wins the LoadAndDelete actually cancels.
+func releaseContextHandle(handle C.uintptr_t) bool {
+	key := uintptr(handle)
+
+	cancel, ok := contextHandles.LoadAndDelete(key)
+	if !ok {
+		return false
+	}
+	contextHandlesCtx.Delete(key)
+
+	cancel.(context.CancelFunc)()
+
+	return true
+}
+
 func parseCArgs(argc C.int, argv **C.char) []string {
 	args := make([]string, 0, argc)
 	for i := 0; i < int(argc); i++ {
@@ -81,24 +186,52 @@ var (
 	azureKeychain  authn.Keychain = authn.NewKeychainFromHelper(credhelper.NewACRCredentialsHelper())
 )
 
-func kraneMain(args []string, inherited bool, outBuffer *bytes.Buffer, errBuffer *bytes.Buffer) uint {
-	keychain := authn.NewMultiKeychain(
+// defaultKeychain builds the multi-keychain shared by every entry point: the static credentials
+// and credential helpers registered at runtime, then the usual cloud-provider and docker-config
+// keychains, plus (when a registry config directory is set) the static credentials it describes,
+// resolved first.
+func defaultKeychain() authn.Keychain {
+	registryKeychain := currentRegistryKeychain()
+
+	keychains := []authn.Keychain{staticCredentialKeychain{}}
+	keychains = append(keychains, registeredCredentialHelpers()...)
+	keychains = append(keychains,
 		authn.DefaultKeychain,
 		google.Keychain,
 		github.Keychain,
 		amazonKeychain,
 		azureKeychain,
 	)
+	if registryKeychain != nil {
+		keychains = append([]authn.Keychain{registryKeychain}, keychains...)
+	}
+	return authn.NewMultiKeychain(keychains...)
+}
+
+// composedTransport chains the registry mirror and blob cache transports (in that order, cache
+// closest to the caller so a cache hit never even consults the mirror config) on top of the
+// default transport, skipping either layer that isn't configured.
+func composedTransport() http.RoundTripper {
+	transport := wrapRegistryMirrorTransport(http.DefaultTransport)
+	return wrapBlobCacheTransport(transport)
+}
+
+func kraneMain(ctx context.Context, args []string, inherited bool, outBuffer *bytes.Buffer, errBuffer *bytes.Buffer) uint {
+	opts := []crane.Option{
+		crane.WithAuthFromKeychain(defaultKeychain()),
+		crane.WithTransport(composedTransport()),
+	}
 
-	// Same as crane, but override usage and keychain.
-	root := cmd.New(use, short, []crane.Option{crane.WithAuthFromKeychain(keychain)})
+	// Same as crane, but override usage and keychain, plus our own sign/verify subcommands.
+	root := cmd.New(use, short, opts)
+	root.AddCommand(newSignCmd(), newVerifyCmd())
 	root.SetArgs(args)
 	if !inherited {
 		root.SetOut(outBuffer)
 		root.SetErr(errBuffer)
 	}
 
-	if err := root.Execute(); err != nil {
+	if err := root.ExecuteContext(ctx); err != nil {
 		return 1
 	} else {
 		return 0
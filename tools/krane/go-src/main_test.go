@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestReleaseContextHandleCleansUpBothMaps(t *testing.T) {
+	handle := krane_new_context()
+	key := uintptr(handle)
+
+	if _, ok := contextHandles.Load(key); !ok {
+		t.Fatal("expected krane_new_context to register a cancel func")
+	}
+	if _, ok := contextHandlesCtx.Load(key); !ok {
+		t.Fatal("expected krane_new_context to register a context")
+	}
+
+	if !releaseContextHandle(handle) {
+		t.Fatal("expected the first release of a live handle to succeed")
+	}
+
+	if _, ok := contextHandles.Load(key); ok {
+		t.Error("expected releaseContextHandle to remove the cancel func")
+	}
+	if _, ok := contextHandlesCtx.Load(key); ok {
+		t.Error("expected releaseContextHandle to remove the context")
+	}
+
+	ctx := contextForHandle(handle)
+	if ctx.Err() != nil {
+		t.Error("contextForHandle should fall back to a fresh background context for a released handle")
+	}
+}
+
+func TestReleaseContextHandleIsIdempotent(t *testing.T) {
+	handle := krane_new_context()
+
+	if !releaseContextHandle(handle) {
+		t.Fatal("expected the first release to succeed")
+	}
+	if releaseContextHandle(handle) {
+		t.Error("expected a second release of the same handle to report nothing left to release")
+	}
+	if krane_cancel(handle) == 0 {
+		t.Error("expected krane_cancel on an already-released handle to report a double-free")
+	}
+}
+
+func TestReleaseContextHandleCancelsContext(t *testing.T) {
+	handle := krane_new_context()
+	key := uintptr(handle)
+
+	ctx := contextForHandle(handle)
+	releaseContextHandle(handle)
+
+	if ctx.Err() == nil {
+		t.Error("expected the context handed out by krane_new_context to be canceled once its handle is released")
+	}
+	if _, ok := contextHandles.Load(key); ok {
+		t.Error("expected the handle to be gone from contextHandles after release")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+func writeHostsToml(t *testing.T, dir, host, contents string) {
+	t.Helper()
+
+	hostDir := filepath.Join(dir, host)
+	if err := os.MkdirAll(hostDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(hostDir, "hosts.toml"), []byte(contents), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestLoadRegistryConfigParsesMirrorsAndCapabilities(t *testing.T) {
+	dir := t.TempDir()
+	writeHostsToml(t, dir, "docker.io", `
+[host."https://mirror.example.com"]
+capabilities = ["pull", "resolve"]
+skip_verify = true
+token = "s3cr3t"
+`)
+
+	configs, err := loadRegistryConfig(dir)
+	if err != nil {
+		t.Fatalf("loadRegistryConfig: %v", err)
+	}
+
+	cfg, ok := configs["docker.io"]
+	if !ok {
+		t.Fatalf("expected config for docker.io, got %v", configs)
+	}
+	if len(cfg.mirrors) != 1 {
+		t.Fatalf("expected 1 mirror, got %d", len(cfg.mirrors))
+	}
+
+	mirror := cfg.mirrors[0]
+	if mirror.endpoint != `https://mirror.example.com` {
+		t.Errorf("endpoint = %q, want mirror.example.com URL", mirror.endpoint)
+	}
+	if !mirror.capabilities["pull"] || !mirror.capabilities["resolve"] {
+		t.Errorf("capabilities = %v, want pull+resolve", mirror.capabilities)
+	}
+	if mirror.capabilities["push"] {
+		t.Errorf("capabilities = %v, push should be false", mirror.capabilities)
+	}
+	if mirror.auth == nil {
+		t.Fatal("expected a bearer authenticator from the configured token")
+	}
+}
+
+func TestLoadRegistryConfigMissingHostsTomlIsSkipped(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "empty-host"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	configs, err := loadRegistryConfig(dir)
+	if err != nil {
+		t.Fatalf("loadRegistryConfig: %v", err)
+	}
+	if _, ok := configs["empty-host"]; ok {
+		t.Errorf("expected no config for a host directory without hosts.toml")
+	}
+}
+
+func TestParseMirrorEndpoint(t *testing.T) {
+	u, err := parseMirrorEndpoint("https://mirror.example.com:5000/base")
+	if err != nil {
+		t.Fatalf("parseMirrorEndpoint: %v", err)
+	}
+	if u.Host != "mirror.example.com:5000" {
+		t.Errorf("Host = %q, want mirror.example.com:5000", u.Host)
+	}
+	if u.Path != "/base" {
+		t.Errorf("Path = %q, want /base", u.Path)
+	}
+}
+
+func TestLoadRegistryConfigDockerIoAliasesToIndexDockerIo(t *testing.T) {
+	dir := t.TempDir()
+	writeHostsToml(t, dir, "docker.io", `
+[host."https://mirror.example.com"]
+capabilities = ["pull", "resolve"]
+token = "s3cr3t"
+`)
+
+	configs, err := loadRegistryConfig(dir)
+	if err != nil {
+		t.Fatalf("loadRegistryConfig: %v", err)
+	}
+
+	cfg, ok := configs["index.docker.io"]
+	if !ok {
+		t.Fatalf(`expected config aliased to "index.docker.io" (the host go-containerregistry actually normalizes docker.io references to), got %v`, configs)
+	}
+	if cfg != configs["docker.io"] {
+		t.Errorf("expected docker.io and index.docker.io to share the same *hostConfig")
+	}
+}
+
+func TestLoadRegistryConfigSkipsBadHostButKeepsOthers(t *testing.T) {
+	dir := t.TempDir()
+	writeHostsToml(t, dir, "bad.example.com", `not valid toml [[[`)
+	writeHostsToml(t, dir, "good.example.com", `
+[host."https://mirror.example.com"]
+capabilities = ["pull"]
+`)
+
+	configs, err := loadRegistryConfig(dir)
+	if err != nil {
+		t.Fatalf("loadRegistryConfig: %v", err)
+	}
+
+	if _, ok := configs["bad.example.com"]; ok {
+		t.Errorf("expected no config for the host with malformed hosts.toml")
+	}
+	if _, ok := configs["good.example.com"]; !ok {
+		t.Errorf("expected the well-formed host's config to still load, got %v", configs)
+	}
+}
+
+func TestMirrorTransportAndStaticKeychainMatchDockerIoByIndexHost(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("expected the mirror request to carry the configured bearer token")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mirror.Close()
+
+	dir := t.TempDir()
+	writeHostsToml(t, dir, "docker.io", `
+[host."`+mirror.URL+`"]
+capabilities = ["pull", "resolve"]
+token = "s3cr3t"
+`)
+
+	configs, err := loadRegistryConfig(dir)
+	if err != nil {
+		t.Fatalf("loadRegistryConfig: %v", err)
+	}
+
+	transport := newMirrorTransport(configs)
+	req, err := http.NewRequest(http.MethodGet, "https://index.docker.io/v2/library/alpine/manifests/latest", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200 from the configured docker.io mirror", resp.StatusCode)
+	}
+
+	keychain := staticKeychain{configs: configs}
+	auth, err := keychain.Resolve(fakeRegistryResource{"index.docker.io"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if auth == authn.Anonymous {
+		t.Errorf("expected the docker.io hosts.toml token to resolve for index.docker.io, got Anonymous")
+	}
+}
+
+// fakeRegistryResource is a minimal authn.Resource for exercising staticKeychain.Resolve without
+// constructing a full name.Registry.
+type fakeRegistryResource struct{ registry string }
+
+func (r fakeRegistryResource) String() string      { return r.registry }
+func (r fakeRegistryResource) RegistryStr() string { return r.registry }
+
+func TestMirrorTransportFallsThroughOn404(t *testing.T) {
+	badMirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer badMirror.Close()
+
+	goodMirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer goodMirror.Close()
+
+	configs := map[string]*hostConfig{
+		"registry.example.com": {
+			host: "registry.example.com",
+			mirrors: []mirrorConfig{
+				{endpoint: badMirror.URL, capabilities: map[string]bool{"pull": true}, transport: http.DefaultTransport.(*http.Transport).Clone()},
+				{endpoint: goodMirror.URL, capabilities: map[string]bool{"pull": true}, transport: http.DefaultTransport.(*http.Transport).Clone()},
+			},
+		},
+	}
+
+	transport := newMirrorTransport(configs)
+	req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/foo/manifests/latest", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200 (expected fallthrough to the good mirror)", resp.StatusCode)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+// This file adds `sign` and `verify` subcommands to the krane root command, implementing a
+// minimal OCI 1.1 referrers-based signature scheme: `sign` attaches a signature artifact whose
+// `subject` points at the signed image and that shows up in the image's referrers index; `verify`
+// walks that referrers index, fetches candidate signature artifacts, and checks them against a
+// caller-supplied public key. This covers the same shape as cosign's attach/verify flow without
+// pulling in the whole sigstore stack.
+//
+// Scope: only key-based sign/verify is implemented. Keyless (Fulcio/Rekor) verification is an
+// intentionally scoped-out follow-up -- `verify` accepts --certificate/--rekor-url and rejects
+// them with a clear "not yet implemented" error, rather than silently ignoring them, but wiring
+// up a real Rekor client is separate future work.
+
+import (
+	"C"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/spf13/cobra"
+)
+
+// signatureArtifactType is the media type krane uses for the signature payload it attaches,
+// distinguishing our signatures from arbitrary referrers another tool might have pushed.
+const signatureArtifactType = "application/vnd.twoliter.krane.signature.v1+json"
+
+// signaturePayload is the content of the single layer in a signature artifact.
+type signaturePayload struct {
+	Subject     string            `json:"subject"`
+	Signature   string            `json:"signature"` // base64-encoded ASN.1 ECDSA signature
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+//export krane_sign
+func krane_sign(argc C.int, argv **C.char, stdout **C.char, stderr **C.char) C.int {
+	return runSubcommand("sign", argc, argv, stdout, stderr)
+}
+
+//export krane_verify
+func krane_verify(argc C.int, argv **C.char, stdout **C.char, stderr **C.char) C.int {
+	return runSubcommand("verify", argc, argv, stdout, stderr)
+}
+
+// runSubcommand is a thin convenience wrapper so C callers can invoke `sign`/`verify` directly
+// without needing to know they're ordinary krane subcommands under the hood.
+func runSubcommand(subcommand string, argc C.int, argv **C.char, stdout **C.char, stderr **C.char) C.int {
+	args := append([]string{subcommand}, parseCArgs(argc, argv)...)
+
+	var outBuffer, errBuffer bytes.Buffer
+	statusCode := kraneMain(context.Background(), args, false, &outBuffer, &errBuffer)
+
+	*stdout = C.CString(outBuffer.String())
+	*stderr = C.CString(errBuffer.String())
+
+	return C.int(statusCode)
+}
+
+func newSignCmd() *cobra.Command {
+	var keyPath string
+	var annotations map[string]string
+
+	cmd := &cobra.Command{
+		Use:   "sign IMAGE",
+		Short: "Sign an image and push the signature as an OCI 1.1 referrer",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyPath == "" {
+				return fmt.Errorf("--key is required")
+			}
+			return signImage(cmd.Context(), args[0], keyPath, annotations)
+		},
+	}
+
+	cmd.Flags().StringVar(&keyPath, "key", "", "path to a PEM-encoded EC private key")
+	cmd.Flags().StringToStringVar(&annotations, "annotation", nil, "annotations to embed in the signature payload")
+
+	return cmd
+}
+
+func newVerifyCmd() *cobra.Command {
+	var keyPath string
+	var certificate string
+	var rekorURL string
+
+	cmd := &cobra.Command{
+		Use:   "verify IMAGE",
+		Short: "Verify an image's signature against its OCI 1.1 referrers",
+		Long: "Verify an image's signature against its OCI 1.1 referrers.\n\n" +
+			"Only key-based verification (--key) is implemented. Keyless (Fulcio/Rekor) " +
+			"verification is a scoped-out follow-up: it needs a full Rekor client, which --certificate " +
+			"and --rekor-url are reserved for but do not yet wire up.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if certificate != "" || rekorURL != "" {
+				return fmt.Errorf("keyless verification via --certificate/--rekor-url is not yet implemented")
+			}
+			if keyPath == "" {
+				return fmt.Errorf("--key is required")
+			}
+			return verifyImage(cmd.Context(), args[0], keyPath)
+		},
+	}
+
+	cmd.Flags().StringVar(&keyPath, "key", "", "path to a PEM-encoded EC public key")
+	cmd.Flags().StringVar(&certificate, "certificate", "", "path to a Fulcio signing certificate (keyless, not yet implemented)")
+	cmd.Flags().StringVar(&rekorURL, "rekor-url", "", "Rekor transparency log URL (keyless, not yet implemented)")
+
+	return cmd
+}
+
+func signImage(ctx context.Context, refStr string, keyPath string, annotations map[string]string) error {
+	ref, desc, err := resolveDigest(ctx, refStr)
+	if err != nil {
+		return err
+	}
+	digest := desc.Digest
+
+	key, err := loadECPrivateKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("loading signing key: %w", err)
+	}
+
+	payload := signaturePayload{Subject: digest.String(), Annotations: annotations}
+
+	hash := sha256.Sum256([]byte(payload.Subject))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return fmt.Errorf("signing digest: %w", err)
+	}
+	payload.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding signature payload: %w", err)
+	}
+
+	layer := static.NewLayer(payloadBytes, types.MediaType(signatureArtifactType))
+
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer})
+	if err != nil {
+		return fmt.Errorf("assembling signature artifact: %w", err)
+	}
+	// Giving the config a non-config media type is how go-containerregistry surfaces an
+	// ArtifactType for this manifest, both in its own client-side referrers fallback and in
+	// registries that derive ArtifactType from Config.MediaType for pre-OCI-1.1 artifacts.
+	img = mutate.ConfigMediaType(img, types.MediaType(signatureArtifactType))
+	img = mutate.Subject(img, mustSubject(desc)).(v1.Image)
+
+	sigRef := ref.Context().Tag(signatureTag(digest))
+
+	return remote.Write(sigRef, img, remote.WithContext(ctx), remote.WithAuthFromKeychain(defaultKeychain()), remote.WithTransport(composedTransport()))
+}
+
+func verifyImage(ctx context.Context, refStr string, keyPath string) error {
+	ref, desc, err := resolveDigest(ctx, refStr)
+	if err != nil {
+		return err
+	}
+	digest := desc.Digest
+
+	pub, err := loadECPublicKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("loading verification key: %w", err)
+	}
+
+	digestRef := ref.Context().Digest(digest.String())
+
+	idx, err := remote.Referrers(digestRef, remote.WithContext(ctx), remote.WithAuthFromKeychain(defaultKeychain()), remote.WithTransport(composedTransport()))
+	if err != nil {
+		return fmt.Errorf("listing referrers for %s: %w", digest, err)
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("reading referrers index: %w", err)
+	}
+
+	for _, desc := range manifest.Manifests {
+		if string(desc.ArtifactType) != signatureArtifactType && string(desc.MediaType) != signatureArtifactType {
+			continue
+		}
+
+		ok, err := verifySignatureArtifact(ctx, ref.Context().Digest(desc.Digest.String()), digest.String(), pub)
+		if err != nil || !ok {
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no valid signature found for %s", digest)
+}
+
+func verifySignatureArtifact(ctx context.Context, sigRef name.Digest, wantSubject string, pub *ecdsa.PublicKey) (bool, error) {
+	img, err := remote.Image(sigRef, remote.WithContext(ctx), remote.WithAuthFromKeychain(defaultKeychain()), remote.WithTransport(composedTransport()))
+	if err != nil {
+		return false, err
+	}
+
+	layers, err := img.Layers()
+	if err != nil || len(layers) != 1 {
+		return false, fmt.Errorf("unexpected signature artifact layout")
+	}
+
+	rc, err := layers[0].Uncompressed()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return false, err
+	}
+
+	var payload signaturePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return false, err
+	}
+	if payload.Subject != wantSubject {
+		return false, fmt.Errorf("signature subject %q does not match %q", payload.Subject, wantSubject)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(payload.Signature)
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha256.Sum256([]byte(payload.Subject))
+	return ecdsa.VerifyASN1(pub, hash[:], sig), nil
+}
+
+// resolveDigest resolves refStr (a tag or digest reference) to its parsed name.Reference and the
+// full descriptor (digest, size, and media type) of the manifest it points at.
+func resolveDigest(ctx context.Context, refStr string) (name.Reference, v1.Descriptor, error) {
+	ref, err := name.ParseReference(refStr)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("parsing reference %q: %w", refStr, err)
+	}
+
+	desc, err := crane.Head(refStr, crane.WithContext(ctx), crane.WithAuthFromKeychain(defaultKeychain()), crane.WithTransport(composedTransport()))
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("resolving %q: %w", refStr, err)
+	}
+
+	return ref, *desc, nil
+}
+
+// mustSubject builds the `subject` descriptor embedded in a signature artifact, using the
+// signed manifest's own media type so the referrers relationship round-trips correctly whether
+// the subject is a plain image manifest, a Docker schema2 manifest, or an index.
+func mustSubject(desc v1.Descriptor) v1.Descriptor {
+	return v1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}
+}
+
+// signatureTag mirrors cosign's sha256-<hex>.sig convention so signature artifacts are easy to
+// spot in a repo listing even on registries without OCI 1.1 referrers support.
+func signatureTag(digest v1.Hash) string {
+	return fmt.Sprintf("%s-%s.sig", digest.Algorithm, digest.Hex)
+}
+
+func loadECPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing EC private key: %w", err)
+	}
+	return key, nil
+}
+
+func loadECPublicKey(path string) (*ecdsa.PublicKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing EC public key: %w", err)
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key at %q is not an EC public key", path)
+	}
+	return key, nil
+}
+
+func readPEMBlock(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %q", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// writePEMKeyPair generates an EC key pair and writes both halves as PEM files, returning their
+// paths for use with `krane sign --key` / `krane verify --key`.
+func writePEMKeyPair(t *testing.T) (privPath, pubPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	privBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	dir := t.TempDir()
+	privPath = filepath.Join(dir, "priv.pem")
+	pubPath = filepath.Join(dir, "pub.pem")
+
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}), 0o600); err != nil {
+		t.Fatalf("writing private key: %v", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0o644); err != nil {
+		t.Fatalf("writing public key: %v", err)
+	}
+	return privPath, pubPath
+}
+
+func pushRandomImage(t *testing.T, ref string) {
+	t.Helper()
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image: %v", err)
+	}
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	if err := remote.Write(parsed, img); err != nil {
+		t.Fatalf("pushing test image: %v", err)
+	}
+}
+
+// TestSignThenVerifyRoundTrip pushes a random image to an in-memory registry, signs it, then
+// verifies it, exercising the full OCI 1.1 referrers round trip: sign must push a manifest whose
+// ArtifactType verify can actually find via remote.Referrers.
+func TestSignThenVerifyRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+	registryHost := strings.TrimPrefix(srv.URL, "http://")
+
+	ref := registryHost + "/repo:latest"
+	pushRandomImage(t, ref)
+
+	privPath, pubPath := writePEMKeyPair(t)
+
+	if err := signImage(context.Background(), ref, privPath, map[string]string{"ci": "true"}); err != nil {
+		t.Fatalf("signImage: %v", err)
+	}
+
+	if err := verifyImage(context.Background(), ref, pubPath); err != nil {
+		t.Fatalf("verifyImage: %v", err)
+	}
+}
+
+// TestVerifyFailsWithWrongKey confirms verify doesn't just check that a referrer artifact
+// exists -- it has to actually validate the signature against the given public key.
+func TestVerifyFailsWithWrongKey(t *testing.T) {
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+	registryHost := strings.TrimPrefix(srv.URL, "http://")
+
+	ref := registryHost + "/repo:latest"
+	pushRandomImage(t, ref)
+
+	privPath, _ := writePEMKeyPair(t)
+	_, otherPub := writePEMKeyPair(t)
+
+	if err := signImage(context.Background(), ref, privPath, nil); err != nil {
+		t.Fatalf("signImage: %v", err)
+	}
+
+	if err := verifyImage(context.Background(), ref, otherPub); err == nil {
+		t.Fatal("expected verify to fail against a key that never signed this image")
+	}
+}